@@ -0,0 +1,25 @@
+package api
+
+// EgressNetworkPolicyRuleType is whether a rule allows or denies traffic
+// matching its CIDR.
+type EgressNetworkPolicyRuleType string
+
+const (
+	EgressNetworkPolicyRuleAllow EgressNetworkPolicyRuleType = "Allow"
+	EgressNetworkPolicyRuleDeny  EgressNetworkPolicyRuleType = "Deny"
+)
+
+// EgressNetworkPolicyRule is a single, ordered Allow/Deny CIDR match.
+type EgressNetworkPolicyRule struct {
+	Type EgressNetworkPolicyRuleType
+	To   string
+}
+
+// EgressNetworkPolicy restricts which external destinations pods in a
+// namespace may reach. Rules are evaluated in order; once a namespace has
+// an EgressNetworkPolicy, any destination none of its rules allow is denied.
+type EgressNetworkPolicy struct {
+	Name      string
+	Namespace string
+	Rules     []EgressNetworkPolicyRule
+}
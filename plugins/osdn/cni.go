@@ -0,0 +1,53 @@
+package osdn
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/plugins/osdn/cniserver"
+)
+
+// startCNIServer, which NewBaseController registers with AddStartNodeFunc,
+// starts the osdn-cni-server in the background, so the osdn-cni shim kubelet
+// invokes for every CNI ADD/DEL/CHECK/VERSION funnels through oc.handleCNIRequest instead of doing
+// its own OVS/VNID bookkeeping.
+func startCNIServer(oc *OvsController) error {
+	srv := cniserver.New(cniserver.DefaultSocketPath, oc.handleCNIRequest)
+	go func() {
+		if err := srv.Start(context.Background()); err != nil {
+			log.Errorf("osdn-cni-server exited: %v", err)
+		}
+	}()
+	return nil
+}
+
+// handleCNIRequest implements cniserver.PodHandler. It enforces VNID/namespace
+// isolation before anything else happens: a pod whose namespace has no known
+// VNID is refused rather than silently networked. On ADD it also calls
+// FlowController.UpdatePod so the OVS flow state for the pod's VNID is
+// updated in lockstep with the CNI invocation that just brought it up.
+//
+// TODO: veth creation, OVS port attachment, and pod IP allocation aren't
+// implemented in this tree; ADD only enforces isolation and notifies the
+// FlowController.
+func (oc *OvsController) handleCNIRequest(req *cniserver.PodRequest) (*cniserver.PodResult, error) {
+	switch req.Command {
+	case cniserver.CNI_ADD:
+		netID, ok := oc.VNIDMap[req.PodNamespace]
+		if !ok {
+			return nil, fmt.Errorf("no VNID known for namespace %q", req.PodNamespace)
+		}
+		if oc.flowController != nil {
+			if err := oc.flowController.UpdatePod(req.PodNamespace, req.PodName, req.ContainerID, netID); err != nil {
+				return nil, err
+			}
+		}
+		return &cniserver.PodResult{}, nil
+	case cniserver.CNI_DEL, cniserver.CNI_CHECK, cniserver.CNI_VERSION:
+		return &cniserver.PodResult{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported CNI command: %q", req.Command)
+	}
+}
@@ -0,0 +1,64 @@
+package osdn
+
+import (
+	"testing"
+
+	"github.com/openshift/openshift-sdn/plugins/osdn/cniserver"
+)
+
+func TestHandleCNIRequestRefusesUnknownNamespace(t *testing.T) {
+	oc := newTestOvsController(&fakeFlowController{})
+
+	_, err := oc.handleCNIRequest(&cniserver.PodRequest{
+		Command:      cniserver.CNI_ADD,
+		PodNamespace: "unknown-ns",
+		PodName:      "mypod",
+		ContainerID:  "abc123",
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a namespace with no known VNID")
+	}
+}
+
+func TestHandleCNIRequestAddUpdatesFlowControllerPod(t *testing.T) {
+	flowController := &fakeFlowController{}
+	oc := newTestOvsController(flowController)
+	oc.VNIDMap["ns-a"] = 42
+
+	result, err := oc.handleCNIRequest(&cniserver.PodRequest{
+		Command:      cniserver.CNI_ADD,
+		PodNamespace: "ns-a",
+		PodName:      "mypod",
+		ContainerID:  "abc123",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result == nil {
+		t.Fatalf("expected a non-nil result")
+	}
+
+	if len(flowController.podUpdates) != 1 {
+		t.Fatalf("expected FlowController.UpdatePod to be called once, got %d calls", len(flowController.podUpdates))
+	}
+	update := flowController.podUpdates[0]
+	if update.namespace != "ns-a" || update.podName != "mypod" || update.containerID != "abc123" || update.netID != 42 {
+		t.Errorf("unexpected UpdatePod call: %+v", update)
+	}
+}
+
+func TestHandleCNIRequestDelIsANoop(t *testing.T) {
+	flowController := &fakeFlowController{}
+	oc := newTestOvsController(flowController)
+	oc.VNIDMap["ns-a"] = 42
+
+	if _, err := oc.handleCNIRequest(&cniserver.PodRequest{
+		Command:      cniserver.CNI_DEL,
+		PodNamespace: "ns-a",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(flowController.podUpdates) != 0 {
+		t.Errorf("expected DEL not to call UpdatePod, got %d calls", len(flowController.podUpdates))
+	}
+}
@@ -0,0 +1,29 @@
+package cniserver
+
+// CNICommand is the CNI operation being requested of the server.
+type CNICommand string
+
+const (
+	CNI_ADD     CNICommand = "ADD"
+	CNI_DEL     CNICommand = "DEL"
+	CNI_CHECK   CNICommand = "CHECK"
+	CNI_VERSION CNICommand = "VERSION"
+)
+
+// PodRequest is the JSON body the osdn-cni shim POSTs to the osdn-cni-server
+// for every CNI invocation kubelet asks it to forward.
+type PodRequest struct {
+	Command      CNICommand `json:"command"`
+	PodNamespace string     `json:"podNamespace"`
+	PodName      string     `json:"podName"`
+	ContainerID  string     `json:"containerId"`
+	Netns        string     `json:"netns,omitempty"`
+	IfName       string     `json:"ifName,omitempty"`
+}
+
+// PodResult is the JSON body the server returns to the shim in response to
+// a PodRequest.
+type PodResult struct {
+	IP    string `json:"ip,omitempty"`
+	Error string `json:"error,omitempty"`
+}
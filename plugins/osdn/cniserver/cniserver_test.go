@@ -0,0 +1,102 @@
+package cniserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errFakeFailure = errors.New("fake handler failure")
+
+func TestShimAgainstFakeServer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cniserver-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "cni-server.sock")
+
+	var gotReq *PodRequest
+	srv := New(socketPath, func(req *PodRequest) (*PodResult, error) {
+		gotReq = req
+		return &PodResult{IP: "10.1.2.3"}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Start(ctx) }()
+
+	if err := waitForSocket(socketPath, time.Second); err != nil {
+		t.Fatalf("server never started listening: %v", err)
+	}
+
+	req := &PodRequest{
+		Command:      CNI_ADD,
+		PodNamespace: "default",
+		PodName:      "mypod",
+		ContainerID:  "abc123",
+	}
+	result, err := Send(socketPath, req)
+	if err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	if result.IP != "10.1.2.3" {
+		t.Errorf("expected IP 10.1.2.3, got %q", result.IP)
+	}
+	if gotReq == nil || gotReq.PodName != "mypod" {
+		t.Errorf("server did not see the forwarded request: %+v", gotReq)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Start returned error after shutdown: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("server did not shut down in time")
+	}
+}
+
+func TestSendErrorFromHandler(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cniserver-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "cni-server.sock")
+
+	srv := New(socketPath, func(req *PodRequest) (*PodResult, error) {
+		return nil, errFakeFailure
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Start(ctx)
+
+	if err := waitForSocket(socketPath, time.Second); err != nil {
+		t.Fatalf("server never started listening: %v", err)
+	}
+
+	_, err = Send(socketPath, &PodRequest{Command: CNI_DEL})
+	if err == nil {
+		t.Fatalf("expected an error from Send, got nil")
+	}
+}
+
+func waitForSocket(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return os.ErrNotExist
+}
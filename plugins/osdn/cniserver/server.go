@@ -0,0 +1,90 @@
+package cniserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+)
+
+// DefaultSocketPath is the Unix domain socket the osdn-cni-server listens on
+// and the osdn-cni shim connects to, when not overridden for testing.
+const DefaultSocketPath = "/var/run/openshift-sdn/cni-server.sock"
+
+// PodHandler performs the actual network setup/teardown for a pod (veth
+// creation, OVS port attachment, FlowController.UpdatePod, VNID/namespace
+// isolation, ...) and is supplied by whatever owns the OvsController.
+type PodHandler func(req *PodRequest) (*PodResult, error)
+
+// Server is a long-running process, registered via
+// OvsController.AddStartNodeFunc, that listens on a Unix domain socket and
+// serves the ADD/DEL/CHECK/VERSION requests forwarded by the osdn-cni shim
+// that kubelet invokes per the CNI spec. Funneling every CNI invocation
+// through a single server lets us batch OVS flow updates and enforce
+// VNID/namespace isolation in one place, and removes the need to ship OVS
+// client tools inside the per-pod CNI binary.
+type Server struct {
+	socketPath string
+	handler    PodHandler
+	listener   net.Listener
+}
+
+// New returns a Server listening on socketPath that dispatches incoming
+// requests to handler.
+func New(socketPath string, handler PodHandler) *Server {
+	return &Server{socketPath: socketPath, handler: handler}
+}
+
+// Start begins listening on s.socketPath and serving requests until ctx is
+// cancelled. It blocks until the listener is closed.
+func (s *Server) Start(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(s.socketPath), err)
+	}
+	// A stale socket from a previous run would otherwise make Listen fail.
+	os.Remove(s.socketPath)
+
+	l, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.socketPath, err)
+	}
+	s.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRequest)
+	httpServer := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	log.Infof("osdn-cni-server listening on %s", s.socketPath)
+	if err := httpServer.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	var req PodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.handler(&req)
+	if err != nil {
+		result = &PodResult{Error: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error encoding CNI response for pod %s/%s: %v", req.PodNamespace, req.PodName, err)
+	}
+}
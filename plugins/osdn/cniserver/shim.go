@@ -0,0 +1,52 @@
+package cniserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// newUnixClient returns an http.Client that dials socketPath instead of a
+// TCP address.
+func newUnixClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+}
+
+// Send forwards a CNI request to the osdn-cni-server listening on
+// socketPath and returns its response. It is called by the osdn-cni shim
+// binary that kubelet invokes for every ADD/DEL/CHECK/VERSION.
+func Send(socketPath string, req *PodRequest) (*PodResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := newUnixClient(socketPath).Post("http://unix/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact osdn-cni-server at %s: %v", socketPath, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result PodResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+	return &result, nil
+}
@@ -4,17 +4,32 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
 
 	"github.com/openshift/openshift-sdn/pkg/netutils"
 	"github.com/openshift/openshift-sdn/plugins/osdn/api"
+	"github.com/openshift/openshift-sdn/plugins/osdn/ipam"
 
 	utildbus "k8s.io/kubernetes/pkg/util/dbus"
 	kerrors "k8s.io/kubernetes/pkg/util/errors"
 	kexec "k8s.io/kubernetes/pkg/util/exec"
 	"k8s.io/kubernetes/pkg/util/iptables"
+	"k8s.io/kubernetes/pkg/util/sysctl"
+)
+
+const (
+	// egressChain is the stable filter chain FORWARD jumps to; SetupIptables
+	// ensures it exists and the jump is installed once, and it never changes
+	// again. syncEgressNetworkPolicy doesn't write rules into it directly:
+	// it alternates egressChain's own content between egressChainA and
+	// egressChainB (see syncEgressNetworkPolicy) so a re-render never leaves
+	// it half-populated.
+	egressChain  iptables.Chain = "OPENSHIFT-EGRESS"
+	egressChainA iptables.Chain = "OPENSHIFT-EGRESS-A"
+	egressChainB iptables.Chain = "OPENSHIFT-EGRESS-B"
 )
 
 type PluginCtor func(registry *Registry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error)
@@ -28,29 +43,56 @@ type OvsController struct {
 	localIP         string
 	localSubnet     *api.Subnet
 	hostName        string
-	subnetAllocator *netutils.SubnetAllocator
 	sig             chan struct{}
 	ready           chan struct{}
 	flowController  FlowController
 	VNIDMap         map[string]uint
-	netIDManager    *netutils.NetIDAllocator
 	adminNamespaces []string
 	services        map[string]api.Service
 	nodeMtu         uint
 
+	egressPoliciesLock sync.Mutex
+	egressPolicies     map[string][]api.EgressNetworkPolicy
+	// egressActiveChain tracks, per address family, which of egressChainA/B
+	// currently holds the live ruleset egressChain jumps to, so
+	// syncEgressNetworkPolicy knows which one to render the next sync into
+	// and which one it can safely flush once the swap is done.
+	egressActiveChain map[iptables.Protocol]iptables.Chain
+
+	// ipam is the subnet/VNID allocation backend used by StartMaster. It
+	// defaults to a LocalIPAM (which owns its own in-memory allocators)
+	// unless SetIPAM is called first (e.g. to select the etcd-backed
+	// allocator for an HA master).
+	ipam ipam.IPAM
+
+	// sysctlStatus is the outcome of StartNode's sysctl preflight, guarded
+	// by sysctlStatusLock and surfaced via ServeSysctlReadiness.
+	sysctlStatus *SysctlStatus
+
+	// hostToPodSNATSource selects how host-network-originated traffic to
+	// pods/services gets its source address rewritten; see
+	// HostToPodSNATTun0 and HostToPodSNATNodeIP.
+	hostToPodSNATSource string
+
 	startMasterFuncs []startFunc
 	startNodeFuncs   []startFunc
 }
 
 type FlowController interface {
-	Setup(localSubnetCIDR, clusterNetworkCIDR, serviceNetworkCIDR string, mtu uint) error
+	Setup(localSubnetCIDRs, clusterNetworkCIDRs, serviceNetworkCIDRs []string, mtu uint) error
 
-	AddOFRules(nodeIP, nodeSubnetCIDR, localIP string) error
+	AddOFRules(nodeIP string, nodeSubnetCIDRs []string, localIP string) error
 	DelOFRules(nodeIP, localIP string) error
 
 	AddServiceOFRules(netID uint, IP string, protocol api.ServiceProtocol, port uint) error
 	DelServiceOFRules(netID uint, IP string, protocol api.ServiceProtocol, port uint) error
 
+	// UpdateEgressNetworkPolicy programs the same Allow/Deny rules enforced
+	// by the host's OPENSHIFT-EGRESS iptables chain as OVS flows, so egress
+	// is still blocked even if a pod manages to bypass the host netfilter
+	// hooks (e.g. via a macvlan or SR-IOV interface).
+	UpdateEgressNetworkPolicy(netID uint, rules []api.EgressNetworkPolicyRule) error
+
 	UpdatePod(namespace, podName, containerID string, netID uint) error
 }
 
@@ -92,21 +134,45 @@ func NewBaseController(registry *Registry, flowController FlowController, hostna
 		}
 	}
 	log.Infof("Self IP: %s.", selfIP)
-	return &OvsController{
-		registry:         registry,
-		flowController:   flowController,
-		localIP:          selfIP,
-		hostName:         hostname,
-		localSubnet:      nil,
-		subnetAllocator:  nil,
-		VNIDMap:          make(map[string]uint),
-		sig:              make(chan struct{}),
-		ready:            ready,
-		adminNamespaces:  make([]string, 0),
-		services:         make(map[string]api.Service),
-		startMasterFuncs: make([]startFunc, 0),
-		startNodeFuncs:   make([]startFunc, 0),
-	}, nil
+	oc := &OvsController{
+		registry:            registry,
+		flowController:      flowController,
+		localIP:             selfIP,
+		hostName:            hostname,
+		localSubnet:         nil,
+		VNIDMap:             make(map[string]uint),
+		sig:                 make(chan struct{}),
+		ready:               ready,
+		adminNamespaces:     make([]string, 0),
+		services:            make(map[string]api.Service),
+		egressPolicies:      make(map[string][]api.EgressNetworkPolicy),
+		egressActiveChain:   make(map[iptables.Protocol]iptables.Chain),
+		hostToPodSNATSource: HostToPodSNATTun0,
+		startMasterFuncs:    make([]startFunc, 0),
+		startNodeFuncs:      make([]startFunc, 0),
+	}
+	oc.AddStartNodeFunc(startCNIServer)
+	return oc, nil
+}
+
+// SetHostToPodSNATSource selects how host-network-originated traffic to
+// pods/services is SNAT'd; see HostToPodSNATTun0 and HostToPodSNATNodeIP.
+// It must be called before StartNode.
+func (oc *OvsController) SetHostToPodSNATSource(mode string) error {
+	switch mode {
+	case HostToPodSNATTun0, HostToPodSNATNodeIP:
+		oc.hostToPodSNATSource = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown HostToPodSNATSource: %q", mode)
+	}
+}
+
+// SetIPAM overrides the default LocalIPAM backend StartMaster would
+// otherwise construct. Plugin factory functions call this before StartMaster
+// when config requests a different backend (e.g. "etcd" for HA masters).
+func (oc *OvsController) SetIPAM(i ipam.IPAM) {
+	oc.ipam = i
 }
 
 func (oc *OvsController) AddStartMasterFunc(f startFunc) {
@@ -139,6 +205,11 @@ func (oc *OvsController) validateClusterNetwork(networkCIDR string, subnetsInUse
 			errList = append(errList, fmt.Errorf("Failed to parse network address: %s", netStr))
 			continue
 		}
+		if (subnetIP.To4() == nil) != (clusterIP.To4() == nil) {
+			// Different IP family; it is validated against the cluster
+			// network CIDR of its own family instead.
+			continue
+		}
 		if !clusterIPNet.Contains(subnetIP) {
 			errList = append(errList, fmt.Errorf("Error: Existing node subnet: %s is not part of cluster network: %s", netStr, networkCIDR))
 		}
@@ -183,32 +254,151 @@ func (oc *OvsController) validateNetworkConfig(clusterNetworkCIDR, serviceNetwor
 	}
 
 	errList := []error{}
-	if err := oc.validateClusterNetwork(clusterNetworkCIDR, subnetsInUse, hostIPNets); err != nil {
+
+	clusterCIDRsByFamily, err := splitCIDRsByFamily(clusterNetworkCIDR)
+	if err != nil {
 		errList = append(errList, err)
 	}
-	if err := oc.validateServiceNetwork(serviceNetworkCIDR, hostIPNets); err != nil {
+	// A node subnet may itself be a dual-stack "v4,v6" pair; split it so each
+	// member is checked against the cluster network CIDR of its own family.
+	subnetMembers := make([]string, 0, len(subnetsInUse))
+	for _, subnet := range subnetsInUse {
+		for _, member := range strings.Split(subnet, ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				subnetMembers = append(subnetMembers, member)
+			}
+		}
+	}
+	for _, cidr := range clusterCIDRsByFamily {
+		if err := oc.validateClusterNetwork(cidr, subnetMembers, hostIPNets); err != nil {
+			errList = append(errList, err)
+		}
+	}
+
+	serviceCIDRsByFamily, err := splitCIDRsByFamily(serviceNetworkCIDR)
+	if err != nil {
 		errList = append(errList, err)
 	}
+	for _, cidr := range serviceCIDRsByFamily {
+		if err := oc.validateServiceNetwork(cidr, hostIPNets); err != nil {
+			errList = append(errList, err)
+		}
+	}
+
 	return kerrors.NewAggregate(errList)
 }
 
-func (oc *OvsController) StartMaster(clusterNetworkCIDR string, clusterBitsPerSubnet uint, serviceNetworkCIDR string) error {
+// splitCIDRsByFamily takes a cluster or service network CIDR, which may
+// actually be a comma-separated dual-stack pair (one IPv4 member and one
+// IPv6 member), and returns the CIDR(s) present keyed by the iptables
+// protocol family that should enforce them.
+func splitCIDRsByFamily(cidrs string) (map[iptables.Protocol]string, error) {
+	result := make(map[iptables.Protocol]string)
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		family, err := cidrFamily(cidr)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := result[family]; exists {
+			return nil, fmt.Errorf("Network CIDR %q has more than one member of the same IP family", cidrs)
+		}
+		result[family] = cidr
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No valid network CIDR found in %q", cidrs)
+	}
+	return result, nil
+}
+
+// cidrFamily returns the iptables protocol family (IPv4 or IPv6) that a CIDR
+// belongs to.
+func cidrFamily(cidr string) (iptables.Protocol, error) {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse network address: %s", cidr)
+	}
+	if ip.To4() != nil {
+		return iptables.ProtocolIpv4, nil
+	}
+	return iptables.ProtocolIpv6, nil
+}
+
+// Default VNID allocation range; VNIDs below minAllocatableVNID are reserved
+// (0 for the default namespace, 1-9 for future use).
+const (
+	minAllocatableVNID = 10
+	maxAllocatableVNID = 0xFFFFFE
+)
+
+// newIPAMBackend constructs the IPAM backend StartMaster should use, based
+// on the --ipam-backend-style config value ("local", the default, or
+// "etcd" for HA masters).
+func newIPAMBackend(backend string, store ipam.Store, holderIdentity string, clusterNetworkCIDR string, hostSubnetBits uint, subnetsInUse []string) (ipam.IPAM, error) {
+	switch strings.ToLower(backend) {
+	case "", "local":
+		return ipam.NewLocalIPAM(clusterNetworkCIDR, hostSubnetBits, minAllocatableVNID, maxAllocatableVNID, subnetsInUse, nil)
+	case "etcd":
+		return ipam.NewEtcdIPAM(store, holderIdentity, ipam.DefaultLeaseTTL, clusterNetworkCIDR, hostSubnetBits, minAllocatableVNID, maxAllocatableVNID), nil
+	default:
+		return nil, fmt.Errorf("unknown IPAM backend: %q", backend)
+	}
+}
+
+// mergeUnique returns the union of a and b, without duplicates.
+func mergeUnique(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	result := make([]string, 0, len(a)+len(b))
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (oc *OvsController) StartMaster(clusterNetworkCIDR string, clusterBitsPerSubnet uint, serviceNetworkCIDR string, ipamBackend string) error {
 	// Any mismatch in cluster/service network is handled by WriteNetworkConfig
 	// For any new cluster/service network, ensure existing node subnets belong
 	// to the given cluster network and service IPs belong to the given service network
-	if _, err := oc.registry.GetClusterNetworkCIDR(); err != nil {
-		subrange := make([]string, 0)
-		subnets, _, err := oc.registry.GetSubnets()
+	subnets, _, err := oc.registry.GetSubnets()
+	if err != nil {
+		log.Errorf("Error in initializing/fetching subnets: %v", err)
+		return err
+	}
+	subrange := make([]string, 0, len(subnets))
+	for _, sub := range subnets {
+		subrange = append(subrange, sub.SubnetCIDR)
+	}
+
+	if oc.ipam == nil {
+		i, err := newIPAMBackend(ipamBackend, oc.registry, oc.hostName, clusterNetworkCIDR, clusterBitsPerSubnet, subrange)
 		if err != nil {
-			log.Errorf("Error in initializing/fetching subnets: %v", err)
 			return err
 		}
-		for _, sub := range subnets {
-			subrange = append(subrange, sub.SubnetCIDR)
+		oc.ipam = i
+	}
+
+	// Some backends (e.g. the etcd-backed one) expire a lease that goes too
+	// long without being renewed, so a crashed master can't orphan it
+	// forever. Keep this master's leases fresh for as long as it's running,
+	// or they'd start looking abandoned to the very same freshness check.
+	if renewable, ok := oc.ipam.(ipam.Renewable); ok {
+		go oc.renewIPAMLeases(renewable)
+	}
+
+	if _, err := oc.registry.GetClusterNetworkCIDR(); err != nil {
+		// The backend may know about leases (e.g. static reservations) that
+		// haven't made it into the registry's subnet list yet.
+		if backendSubnets, err := oc.ipam.SubnetsInUse(); err == nil {
+			subrange = mergeUnique(subrange, backendSubnets)
 		}
 
-		err = oc.validateNetworkConfig(clusterNetworkCIDR, serviceNetworkCIDR, subrange)
-		if err != nil {
+		if err := oc.validateNetworkConfig(clusterNetworkCIDR, serviceNetworkCIDR, subrange); err != nil {
 			return err
 		}
 	}
@@ -227,28 +417,69 @@ func (oc *OvsController) StartMaster(clusterNetworkCIDR string, clusterBitsPerSu
 	return nil
 }
 
-func (oc *OvsController) StartNode(mtu uint) error {
+// renewIPAMLeases periodically calls renewable.RenewLeases until oc is
+// stopped, so a Renewable ipam backend's leases never go long enough
+// without renewal to look abandoned. See StartMaster.
+func (oc *OvsController) renewIPAMLeases(renewable ipam.Renewable) {
+	ticker := time.NewTicker(ipam.DefaultLeaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := renewable.RenewLeases(); err != nil {
+				log.Errorf("Error renewing IPAM leases: %v", err)
+			}
+		case <-oc.sig:
+			return
+		}
+	}
+}
+
+func (oc *OvsController) StartNode(mtu uint, tuneSysctls bool) error {
 	oc.nodeMtu = mtu
 
-	// Assume we are working with IPv4
+	status, err := sysctlPreflight(sysctl.New(), []string{"tun0", "lbr0"}, tuneSysctls)
+	sysctlStatusLock.Lock()
+	oc.sysctlStatus = status
+	sysctlStatusLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("sysctl preflight failed: %v", err)
+	}
+
+	// clusterNetworkCIDR may be a single IPv4 CIDR, or a comma-separated
+	// dual-stack "v4,v6" pair; install rules for each family present.
 	clusterNetworkCIDR, err := oc.registry.GetClusterNetworkCIDR()
 	if err != nil {
 		log.Errorf("Failed to obtain ClusterNetwork: %v", err)
 		return err
 	}
 
-	ipt := iptables.New(kexec.New(), utildbus.New(), iptables.ProtocolIpv4)
-	err = SetupIptables(ipt, clusterNetworkCIDR)
+	cidrsByFamily, err := splitCIDRsByFamily(clusterNetworkCIDR)
 	if err != nil {
 		return err
 	}
 
-	ipt.AddReloadFunc(func() {
-		err := SetupIptables(ipt, clusterNetworkCIDR)
-		if err != nil {
-			log.Errorf("Error reloading iptables: %v\n", err)
+	for family, cidr := range cidrsByFamily {
+		ipt := iptables.New(kexec.New(), utildbus.New(), family)
+		if err := SetupIptables(ipt, cidr, oc.localIP, oc.hostToPodSNATSource); err != nil {
+			return err
+		}
+
+		family, cidr, ipt := family, cidr, ipt
+		ipt.AddReloadFunc(func() {
+			if err := SetupIptables(ipt, cidr, oc.localIP, oc.hostToPodSNATSource); err != nil {
+				log.Errorf("Error reloading iptables: %v\n", err)
+				return
+			}
+			if err := oc.syncEgressNetworkPolicy(ipt, family); err != nil {
+				log.Errorf("Error re-applying EgressNetworkPolicy for %s: %v", family, err)
+			}
+		})
+
+		if err := oc.StartEgressNetworkPolicy(ipt, family); err != nil {
+			return err
 		}
-	})
+	}
 
 	// Plugin specific startup
 	for _, f := range oc.startNodeFuncs {
@@ -320,7 +551,31 @@ type FirewallRule struct {
 	args  []string
 }
 
-func SetupIptables(ipt iptables.Interface, clusterNetworkCIDR string) error {
+const (
+	// HostToPodSNATTun0 is the default: host-to-pod traffic is left to pick
+	// up tun0's address as its source via the normal routing table, the way
+	// it always has.
+	HostToPodSNATTun0 = "tun0"
+	// HostToPodSNATNodeIP makes host-to-pod traffic instead SNAT to the
+	// node's primary IP, so external ACLs that key off a node's advertised
+	// IP keep working for traffic originated by host-network processes.
+	HostToPodSNATNodeIP = "nodeip"
+)
+
+// addrFamily returns the iptables protocol family (IPv4 or IPv6) of a
+// single IP address (as opposed to cidrFamily, which parses a CIDR).
+func addrFamily(ip string) (iptables.Protocol, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address: %s", ip)
+	}
+	if parsed.To4() != nil {
+		return iptables.ProtocolIpv4, nil
+	}
+	return iptables.ProtocolIpv6, nil
+}
+
+func SetupIptables(ipt iptables.Interface, clusterNetworkCIDR string, nodeIP string, hostToPodSNATSource string) error {
 	rules := []FirewallRule{
 		{"nat", "POSTROUTING", []string{"-s", clusterNetworkCIDR, "!", "-d", clusterNetworkCIDR, "-j", "MASQUERADE"}},
 		{"filter", "INPUT", []string{"-p", "udp", "-m", "multiport", "--dports", "4789", "-m", "comment", "--comment", "001 vxlan incoming", "-j", "ACCEPT"}},
@@ -329,6 +584,16 @@ func SetupIptables(ipt iptables.Interface, clusterNetworkCIDR string) error {
 		{"filter", "FORWARD", []string{"-s", clusterNetworkCIDR, "-j", "ACCEPT"}},
 	}
 
+	if hostToPodSNATSource == HostToPodSNATNodeIP && nodeIP != "" {
+		clusterFamily, err := cidrFamily(clusterNetworkCIDR)
+		if err != nil {
+			return err
+		}
+		if nodeFamily, err := addrFamily(nodeIP); err == nil && nodeFamily == clusterFamily {
+			rules = append(rules, FirewallRule{"nat", "POSTROUTING", []string{"-s", nodeIP + "/32", "-d", clusterNetworkCIDR, "-j", "SNAT", "--to-source", nodeIP}})
+		}
+	}
+
 	for _, rule := range rules {
 		_, err := ipt.EnsureRule(iptables.Prepend, iptables.Table(rule.table), iptables.Chain(rule.chain), rule.args...)
 		if err != nil {
@@ -336,5 +601,202 @@ func SetupIptables(ipt iptables.Interface, clusterNetworkCIDR string) error {
 		}
 	}
 
+	// Make sure the EgressNetworkPolicy chain exists and is jumped to from
+	// FORWARD, so a reload restores the hook point even before any policy
+	// has been rendered into it.
+	if _, err := ipt.EnsureChain(iptables.Table("filter"), egressChain); err != nil {
+		return err
+	}
+	if _, err := ipt.EnsureRule(iptables.Prepend, iptables.Table("filter"), "FORWARD", "-j", string(egressChain)); err != nil {
+		return err
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// setEgressNetworkPolicies records the current EgressNetworkPolicy objects
+// for namespace and re-renders the OPENSHIFT-EGRESS chain to match.
+func (oc *OvsController) setEgressNetworkPolicies(ipt iptables.Interface, family iptables.Protocol, namespace string, policies []api.EgressNetworkPolicy) error {
+	oc.egressPoliciesLock.Lock()
+	if len(policies) == 0 {
+		delete(oc.egressPolicies, namespace)
+	} else {
+		oc.egressPolicies[namespace] = policies
+	}
+	oc.egressPoliciesLock.Unlock()
+
+	// syncEgressNetworkPolicy only visits namespaces that still have
+	// policies, so clear a namespace's OVS backstop here once it has none.
+	if len(policies) == 0 && oc.flowController != nil {
+		if netID, ok := oc.VNIDMap[namespace]; ok {
+			if err := oc.flowController.UpdateEgressNetworkPolicy(netID, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return oc.syncEgressNetworkPolicy(ipt, family)
+}
+
+// syncEgressNetworkPolicy rebuilds family's enforcement of the currently
+// known EgressNetworkPolicy objects, keeping only the rules whose
+// destination CIDR matches ipt's own IP family. Rather than flushing and
+// repopulating the live egressChain in place - which would let egress
+// traffic through unfiltered for the moment between the flush and the last
+// rule being re-added - it renders the full ruleset into whichever of
+// egressChainA/B isn't currently live, then atomically swaps egressChain's
+// jump over to it before flushing the now-retired chain. egressChain itself
+// is therefore never observed empty or partially built.
+func (oc *OvsController) syncEgressNetworkPolicy(ipt iptables.Interface, family iptables.Protocol) error {
+	oc.egressPoliciesLock.Lock()
+	defer oc.egressPoliciesLock.Unlock()
+
+	active := oc.egressActiveChain[family]
+	next := egressChainB
+	if active == egressChainB {
+		next = egressChainA
+	}
+
+	// Build the new chain.
+	if _, err := ipt.EnsureChain(iptables.Table("filter"), next); err != nil {
+		return err
+	}
+	if err := ipt.FlushChain(iptables.Table("filter"), next); err != nil {
+		return err
+	}
+
+	for namespace, policies := range oc.egressPolicies {
+		netID, ok := oc.VNIDMap[namespace]
+		if !ok {
+			log.Warningf("No VNID known for namespace %q; skipping its EgressNetworkPolicy", namespace)
+			continue
+		}
+
+		for _, policy := range policies {
+			for _, rule := range policy.Rules {
+				if ruleFamily, err := cidrFamily(rule.To); err != nil || ruleFamily != family {
+					continue
+				}
+				target := "ACCEPT"
+				if rule.Type == api.EgressNetworkPolicyRuleDeny {
+					target = "DROP"
+				}
+				args := []string{"-m", "mark", "--mark", fmt.Sprintf("%d", netID), "-d", rule.To, "-j", target}
+				if _, err := ipt.EnsureRule(iptables.Append, iptables.Table("filter"), next, args...); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(policies) > 0 {
+			// A namespace with an EgressNetworkPolicy implicitly denies any
+			// destination none of its rules allowed.
+			args := []string{"-m", "mark", "--mark", fmt.Sprintf("%d", netID), "-j", "DROP"}
+			if _, err := ipt.EnsureRule(iptables.Append, iptables.Table("filter"), next, args...); err != nil {
+				return err
+			}
+		}
+
+		// The OVS backstop gets every rule of the namespace, of both
+		// families, so a dual-stack cluster programs the same rules once per
+		// family's sync; UpdateEgressNetworkPolicy overwrites the same
+		// netID's flows each time, so the repeat is harmless.
+		if oc.flowController != nil {
+			var rules []api.EgressNetworkPolicyRule
+			for _, policy := range policies {
+				rules = append(rules, policy.Rules...)
+			}
+			if err := oc.flowController.UpdateEgressNetworkPolicy(netID, rules); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Swap the jump: bring the new chain live before retiring the old one,
+	// so egressChain always has at least one fully-built ruleset installed.
+	if _, err := ipt.EnsureRule(iptables.Prepend, iptables.Table("filter"), egressChain, "-j", string(next)); err != nil {
+		return err
+	}
+	if active != "" && active != next {
+		if err := ipt.DeleteRule(iptables.Table("filter"), egressChain, "-j", string(active)); err != nil {
+			return err
+		}
+		// Flush the old chain now that nothing jumps to it, recycling it
+		// for the next sync.
+		if err := ipt.FlushChain(iptables.Table("filter"), active); err != nil {
+			return err
+		}
+	}
+	oc.egressActiveChain[family] = next
+
+	return nil
+}
+
+// watchEgressNetworkPolicies watches EgressNetworkPolicy objects and keeps
+// the host firewall (and the FlowController's OVS backstop) in sync with
+// them, following the same initiator/WatchProcess handshake documented on
+// watchAndGetResource.
+func (oc *OvsController) watchEgressNetworkPolicies(ipt iptables.Interface, family iptables.Protocol, ready chan<- bool, start <-chan string) {
+	policyEvent := make(chan *api.EgressNetworkPolicy)
+	go oc.registry.WatchEgressNetworkPolicies(policyEvent, ready, start)
+
+	for {
+		select {
+		case policy, ok := <-policyEvent:
+			if !ok {
+				return
+			}
+			policies, _, err := oc.registry.GetEgressNetworkPolicies(policy.Namespace)
+			if err != nil {
+				log.Errorf("Error fetching EgressNetworkPolicies for namespace %s: %v", policy.Namespace, err)
+				continue
+			}
+			if err := oc.setEgressNetworkPolicies(ipt, family, policy.Namespace, policies); err != nil {
+				log.Errorf("Error syncing EgressNetworkPolicy for namespace %s: %v", policy.Namespace, err)
+			}
+		case <-oc.sig:
+			return
+		}
+	}
+}
+
+// StartEgressNetworkPolicy begins enforcing EgressNetworkPolicy objects via
+// ipt. StartNode calls it once per address family in use, right after
+// SetupIptables for that family.
+func (oc *OvsController) StartEgressNetworkPolicy(ipt iptables.Interface, family iptables.Protocol) error {
+	result, err := oc.watchAndGetResource("EgressNetworkPolicies",
+		func(oc *OvsController, ready chan<- bool, start <-chan string) {
+			oc.watchEgressNetworkPolicies(ipt, family, ready, start)
+		},
+		func(registry *Registry) (interface{}, string, error) {
+			return registry.GetAllEgressNetworkPolicies()
+		})
+	if err != nil {
+		return err
+	}
+
+	// Seed oc.egressPolicies (and ipt) with whatever EgressNetworkPolicy
+	// objects already existed before the watch above took over; otherwise a
+	// namespace's policy only gets enforced once some later change happens
+	// to touch that namespace, leaving pre-existing policies unenforced
+	// across a node restart.
+	for namespace, policies := range groupEgressPoliciesByNamespace(result.([]api.EgressNetworkPolicy)) {
+		if err := oc.setEgressNetworkPolicies(ipt, family, namespace, policies); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupEgressPoliciesByNamespace buckets a flat list of EgressNetworkPolicy
+// objects (as returned by Registry.GetAllEgressNetworkPolicies) by their
+// Namespace, matching the per-namespace shape setEgressNetworkPolicies
+// expects.
+func groupEgressPoliciesByNamespace(policies []api.EgressNetworkPolicy) map[string][]api.EgressNetworkPolicy {
+	result := make(map[string][]api.EgressNetworkPolicy)
+	for _, policy := range policies {
+		result[policy.Namespace] = append(result[policy.Namespace], policy)
+	}
+	return result
+}
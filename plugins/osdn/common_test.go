@@ -0,0 +1,311 @@
+package osdn
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/plugins/osdn/api"
+
+	"k8s.io/kubernetes/pkg/util/iptables"
+)
+
+// fakeIPTables is a minimal in-memory iptables.Interface used to assert
+// which rules SetupIptables installs for a given HostToPodSNATSource mode,
+// without touching the host's real iptables.
+type fakeIPTables struct {
+	rules []fakeRule
+}
+
+type fakeRule struct {
+	table iptables.Table
+	chain iptables.Chain
+	args  []string
+}
+
+func (f *fakeIPTables) EnsureChain(table iptables.Table, chain iptables.Chain) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeIPTables) FlushChain(table iptables.Table, chain iptables.Chain) error {
+	kept := f.rules[:0]
+	for _, r := range f.rules {
+		if r.table != table || r.chain != chain {
+			kept = append(kept, r)
+		}
+	}
+	f.rules = kept
+	return nil
+}
+
+func (f *fakeIPTables) DeleteChain(table iptables.Table, chain iptables.Chain) error {
+	return nil
+}
+
+func (f *fakeIPTables) EnsureRule(position iptables.RulePosition, table iptables.Table, chain iptables.Chain, args ...string) (bool, error) {
+	f.rules = append(f.rules, fakeRule{table: table, chain: chain, args: append([]string{}, args...)})
+	return true, nil
+}
+
+func (f *fakeIPTables) DeleteRule(table iptables.Table, chain iptables.Chain, args ...string) error {
+	for i, r := range f.rules {
+		if r.table != table || r.chain != chain || len(r.args) != len(args) {
+			continue
+		}
+		match := true
+		for j, a := range r.args {
+			if a != args[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeIPTables) IsIpv6() bool {
+	return false
+}
+
+func (f *fakeIPTables) SaveInto(table iptables.Table, buffer *bytes.Buffer) error {
+	return nil
+}
+
+func (f *fakeIPTables) Restore(table iptables.Table, data []byte, flush iptables.FlushFlag, counters iptables.RestoreCountersFlag) error {
+	return nil
+}
+
+func (f *fakeIPTables) RestoreAll(data []byte, flush iptables.FlushFlag, counters iptables.RestoreCountersFlag) error {
+	return nil
+}
+
+func (f *fakeIPTables) AddReloadFunc(reloadFunc func()) {}
+
+func (f *fakeIPTables) Destroy() {}
+
+func (f *fakeIPTables) hasRule(table iptables.Table, chain iptables.Chain, needle string) bool {
+	for _, r := range f.rules {
+		if r.table != table || r.chain != chain {
+			continue
+		}
+		for _, a := range r.args {
+			if a == needle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestSetupIptablesDefaultModeHasNoNodeIPSNAT(t *testing.T) {
+	ipt := &fakeIPTables{}
+	if err := SetupIptables(ipt, "10.128.0.0/14", "192.168.1.5", HostToPodSNATTun0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range ipt.rules {
+		for _, a := range r.args {
+			if a == "SNAT" {
+				t.Fatalf("default HostToPodSNATSource mode should not install a SNAT rule, got %v", r.args)
+			}
+		}
+	}
+}
+
+func TestSetupIptablesNodeIPModeAddsSNATRule(t *testing.T) {
+	ipt := &fakeIPTables{}
+	nodeIP := "192.168.1.5"
+	clusterCIDR := "10.128.0.0/14"
+	if err := SetupIptables(ipt, clusterCIDR, nodeIP, HostToPodSNATNodeIP); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	found := false
+	for _, r := range ipt.rules {
+		if r.table != "nat" || r.chain != "POSTROUTING" {
+			continue
+		}
+		for i, a := range r.args {
+			if a == "SNAT" && i+1 < len(r.args) && r.args[i+1] == "--to-source" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected a SNAT --to-source rule in nat/POSTROUTING, got %+v", ipt.rules)
+	}
+	if !ipt.hasRule("nat", "POSTROUTING", nodeIP+"/32") {
+		t.Errorf("expected the SNAT rule to match source %s/32", nodeIP)
+	}
+}
+
+func TestSetupIptablesNodeIPModeSkipsMismatchedFamily(t *testing.T) {
+	ipt := &fakeIPTables{}
+	// An IPv6 node address can't SNAT an IPv4 cluster network.
+	if err := SetupIptables(ipt, "10.128.0.0/14", "fd00::1", HostToPodSNATNodeIP); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, r := range ipt.rules {
+		for _, a := range r.args {
+			if a == "SNAT" {
+				t.Fatalf("mismatched-family node IP should not produce a SNAT rule, got %v", r.args)
+			}
+		}
+	}
+}
+
+// fakeFlowController is a minimal FlowController that records the
+// UpdateEgressNetworkPolicy calls syncEgressNetworkPolicy's OVS backstop
+// makes and the UpdatePod calls handleCNIRequest makes; the other methods
+// are unused by these tests.
+type fakeFlowController struct {
+	egressCalls []struct {
+		netID uint
+		rules []api.EgressNetworkPolicyRule
+	}
+	podUpdates []struct {
+		namespace   string
+		podName     string
+		containerID string
+		netID       uint
+	}
+}
+
+func (f *fakeFlowController) Setup(localSubnetCIDRs, clusterNetworkCIDRs, serviceNetworkCIDRs []string, mtu uint) error {
+	return nil
+}
+
+func (f *fakeFlowController) AddOFRules(nodeIP string, nodeSubnetCIDRs []string, localIP string) error {
+	return nil
+}
+
+func (f *fakeFlowController) DelOFRules(nodeIP, localIP string) error { return nil }
+
+func (f *fakeFlowController) AddServiceOFRules(netID uint, IP string, protocol api.ServiceProtocol, port uint) error {
+	return nil
+}
+
+func (f *fakeFlowController) DelServiceOFRules(netID uint, IP string, protocol api.ServiceProtocol, port uint) error {
+	return nil
+}
+
+func (f *fakeFlowController) UpdateEgressNetworkPolicy(netID uint, rules []api.EgressNetworkPolicyRule) error {
+	f.egressCalls = append(f.egressCalls, struct {
+		netID uint
+		rules []api.EgressNetworkPolicyRule
+	}{netID, rules})
+	return nil
+}
+
+func (f *fakeFlowController) UpdatePod(namespace, podName, containerID string, netID uint) error {
+	f.podUpdates = append(f.podUpdates, struct {
+		namespace   string
+		podName     string
+		containerID string
+		netID       uint
+	}{namespace, podName, containerID, netID})
+	return nil
+}
+
+func newTestOvsController(flowController FlowController) *OvsController {
+	return &OvsController{
+		VNIDMap:           make(map[string]uint),
+		egressPolicies:    make(map[string][]api.EgressNetworkPolicy),
+		egressActiveChain: make(map[iptables.Protocol]iptables.Chain),
+		flowController:    flowController,
+	}
+}
+
+func TestSyncEgressNetworkPolicySwapsChainsAtomically(t *testing.T) {
+	oc := newTestOvsController(&fakeFlowController{})
+	oc.VNIDMap["ns-a"] = 5
+	ipt := &fakeIPTables{}
+
+	policies := []api.EgressNetworkPolicy{{
+		Name:      "default",
+		Namespace: "ns-a",
+		Rules: []api.EgressNetworkPolicyRule{
+			{Type: api.EgressNetworkPolicyRuleDeny, To: "1.2.3.0/24"},
+		},
+	}}
+	if err := oc.setEgressNetworkPolicies(ipt, iptables.ProtocolIpv4, "ns-a", policies); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ipt.hasRule("filter", egressChain, string(egressChainB)) {
+		t.Fatalf("expected egressChain to jump to %s after the first sync, got %+v", egressChainB, ipt.rules)
+	}
+	if !ipt.hasRule("filter", egressChainB, "1.2.3.0/24") {
+		t.Fatalf("expected the rendered rule in %s, got %+v", egressChainB, ipt.rules)
+	}
+
+	// A second sync (e.g. a policy update) must swap to the other backing
+	// chain and retire the first one, never leaving egressChain without a
+	// fully-built jump target.
+	policies[0].Rules[0].To = "5.6.7.0/24"
+	if err := oc.setEgressNetworkPolicies(ipt, iptables.ProtocolIpv4, "ns-a", policies); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ipt.hasRule("filter", egressChain, string(egressChainB)) {
+		t.Fatalf("expected the old jump to %s to be removed, got %+v", egressChainB, ipt.rules)
+	}
+	if !ipt.hasRule("filter", egressChain, string(egressChainA)) {
+		t.Fatalf("expected egressChain to jump to %s after the second sync, got %+v", egressChainA, ipt.rules)
+	}
+	if ipt.hasRule("filter", egressChainB, "1.2.3.0/24") {
+		t.Fatalf("expected the retired chain %s to be flushed, got %+v", egressChainB, ipt.rules)
+	}
+	if !ipt.hasRule("filter", egressChainA, "5.6.7.0/24") {
+		t.Fatalf("expected the new rule in %s, got %+v", egressChainA, ipt.rules)
+	}
+}
+
+func TestGroupEgressPoliciesByNamespace(t *testing.T) {
+	policies := []api.EgressNetworkPolicy{
+		{Name: "default", Namespace: "ns-a", Rules: []api.EgressNetworkPolicyRule{{Type: api.EgressNetworkPolicyRuleDeny, To: "1.2.3.0/24"}}},
+		{Name: "default", Namespace: "ns-b", Rules: []api.EgressNetworkPolicyRule{{Type: api.EgressNetworkPolicyRuleAllow, To: "5.6.7.0/24"}}},
+		{Name: "second", Namespace: "ns-a", Rules: []api.EgressNetworkPolicyRule{{Type: api.EgressNetworkPolicyRuleDeny, To: "8.8.8.0/24"}}},
+	}
+
+	grouped := groupEgressPoliciesByNamespace(policies)
+
+	if len(grouped) != 2 {
+		t.Fatalf("expected 2 namespaces, got %d: %+v", len(grouped), grouped)
+	}
+	if len(grouped["ns-a"]) != 2 {
+		t.Errorf("expected 2 policies for ns-a, got %d", len(grouped["ns-a"]))
+	}
+	if len(grouped["ns-b"]) != 1 {
+		t.Errorf("expected 1 policy for ns-b, got %d", len(grouped["ns-b"]))
+	}
+}
+
+func TestSyncEgressNetworkPolicyProgramsOVSBackstopForIPv6Only(t *testing.T) {
+	flowController := &fakeFlowController{}
+	oc := newTestOvsController(flowController)
+	oc.VNIDMap["ns-a"] = 7
+	ipt := &fakeIPTables{}
+
+	policies := []api.EgressNetworkPolicy{{
+		Name:      "default",
+		Namespace: "ns-a",
+		Rules: []api.EgressNetworkPolicyRule{
+			{Type: api.EgressNetworkPolicyRuleDeny, To: "fd00::/64"},
+		},
+	}}
+	if err := oc.setEgressNetworkPolicies(ipt, iptables.ProtocolIpv6, "ns-a", policies); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(flowController.egressCalls) != 1 {
+		t.Fatalf("expected the OVS backstop to be programmed for an IPv6-only sync, got %d calls", len(flowController.egressCalls))
+	}
+	if flowController.egressCalls[0].netID != 7 {
+		t.Errorf("expected the backstop call for netID 7, got %d", flowController.egressCalls[0].netID)
+	}
+}
@@ -0,0 +1,318 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/openshift/openshift-sdn/plugins/osdn/api"
+)
+
+// DefaultLeaseTTL is how long a subnet/VNID lease is honored without being
+// renewed before it is considered abandoned (e.g. by a master that crashed
+// mid-allocation) and becomes available again.
+const DefaultLeaseTTL = 2 * time.Minute
+
+// Lease is the etcd/CRD-persisted record of who holds a subnet or VNID and
+// until when. Unlike LocalIPAM's in-memory free lists, a Lease is durable
+// across master restarts and carries enough information (holder + TTL) for
+// two masters racing each other to agree on the same outcome.
+type Lease struct {
+	HolderIdentity string
+	// SubnetCIDR is the subnet this lease hands out. Only subnet leases set
+	// it; VNID leases are recorded against the netID itself (see
+	// Store.ListVNIDLeases) so they don't need it.
+	SubnetCIDR string
+	RenewTime  time.Time
+	TTL        time.Duration
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return now.After(l.RenewTime.Add(l.TTL))
+}
+
+// Store is the minimal etcd/CRD access EtcdIPAM needs; it is satisfied by
+// the master's Registry. Subnet leases are keyed by the node they're
+// leased to (and carry their CIDR in Lease.SubnetCIDR), the same way VNID
+// leases are keyed by namespace (and carry their netID as ListVNIDLeases'
+// map key) - so a node/namespace's own lease can always be looked up
+// directly, without scanning every lease for a HolderIdentity match.
+type Store interface {
+	GetSubnetLease(nodeName string) (*Lease, error)
+	CreateSubnetLease(nodeName, subnetCIDR string, lease *Lease) error
+	CompareAndSwapSubnetLease(nodeName string, expectedRenewTime time.Time, lease *Lease) error
+	DeleteSubnetLease(nodeName string) error
+	ListSubnetLeases() (map[string]*Lease, error)
+
+	GetVNIDLease(namespace string) (*Lease, error)
+	CreateVNIDLease(namespace string, netID uint, lease *Lease) error
+	CompareAndSwapVNIDLease(namespace string, netID uint, expectedRenewTime time.Time, lease *Lease) error
+	DeleteVNIDLease(namespace string) error
+	ListVNIDLeases() (map[uint]*Lease, error)
+}
+
+// EtcdIPAM is a CRD/etcd-backed IPAM that persists each lease with a holder
+// identity and a TTL, so a crashed master can't orphan a subnet/VNID
+// forever, and two masters racing to allocate the same one will agree on a
+// single winner via the store's compare-and-swap.
+type EtcdIPAM struct {
+	store          Store
+	holderIdentity string
+	ttl            time.Duration
+
+	// clusterNetworkCIDRs holds one member per cluster network family (it
+	// comes from splitting the possibly comma-joined, dual-stack
+	// clusterNetworkCIDR passed to NewEtcdIPAM); AllocateSubnet draws one
+	// subnet per family and rejoins them into a single SubnetCIDR.
+	clusterNetworkCIDRs []string
+	hostSubnetBits      uint
+	minVNID, maxVNID    uint
+}
+
+// NewEtcdIPAM returns an EtcdIPAM that persists leases to store under
+// holderIdentity (typically this master's hostname), renewing them every
+// ttl. clusterNetworkCIDR may be a single IPv4/IPv6 CIDR or a
+// comma-separated dual-stack "v4,v6" pair.
+func NewEtcdIPAM(store Store, holderIdentity string, ttl time.Duration, clusterNetworkCIDR string, hostSubnetBits, minVNID, maxVNID uint) *EtcdIPAM {
+	return &EtcdIPAM{
+		store:               store,
+		holderIdentity:      holderIdentity,
+		ttl:                 ttl,
+		clusterNetworkCIDRs: splitCIDRList(clusterNetworkCIDR),
+		hostSubnetBits:      hostSubnetBits,
+		minVNID:             minVNID,
+		maxVNID:             maxVNID,
+	}
+}
+
+// nextFreeSubnet returns the first subnet of clusterNetworkCIDR (a
+// single-family CIDR) that leaves hostSubnetBits bits for hosts (e.g. a /23
+// for an IPv4 network with hostSubnetBits 9) and isn't present in inUse.
+func nextFreeSubnet(clusterNetworkCIDR string, hostSubnetBits uint, inUse []string) (string, error) {
+	_, clusterIPNet, err := net.ParseCIDR(clusterNetworkCIDR)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse network address: %s", clusterNetworkCIDR)
+	}
+	ones, bits := clusterIPNet.Mask.Size()
+	subnetBits := uint(bits-ones) - hostSubnetBits
+	if subnetBits < 1 {
+		return "", fmt.Errorf("host subnet bits %d too large for cluster network %s", hostSubnetBits, clusterNetworkCIDR)
+	}
+
+	taken := make(map[string]bool, len(inUse))
+	for _, s := range inUse {
+		taken[s] = true
+	}
+
+	base := clusterIPNet.IP.Mask(clusterIPNet.Mask)
+	numSubnets := uint64(1) << subnetBits
+	if subnetBits >= 64 {
+		// e.g. an IPv6 cluster network; the shift above would overflow to 0.
+		numSubnets = ^uint64(0)
+	}
+	for i := uint64(0); i < numSubnets; i++ {
+		candidate := addSubnetOffset(base, i, uint(hostSubnetBits))
+		candidateNet := &net.IPNet{IP: candidate, Mask: net.CIDRMask(int(ones)+int(subnetBits), bits)}
+		if !clusterIPNet.Contains(candidate) {
+			break
+		}
+		cidr := candidateNet.String()
+		if !taken[cidr] {
+			return cidr, nil
+		}
+	}
+	return "", fmt.Errorf("no subnets available in cluster network %s", clusterNetworkCIDR)
+}
+
+// addSubnetOffset returns base with offset written into the subnet-index
+// bits, which sit just above the hostSubnetBits-wide host portion.
+func addSubnetOffset(base net.IP, offset uint64, hostSubnetBits uint) net.IP {
+	ip := make(net.IP, len(base))
+	copy(ip, base)
+
+	shifted := offset << hostSubnetBits
+	for i := len(ip) - 1; i >= 0 && shifted > 0; i-- {
+		ip[i] |= byte(shifted)
+		shifted >>= 8
+	}
+	return ip
+}
+
+func (a *EtcdIPAM) newLease() *Lease {
+	return &Lease{HolderIdentity: a.holderIdentity, RenewTime: time.Now(), TTL: a.ttl}
+}
+
+// newSubnetLease is newLease for a subnet grant: it also stamps the
+// allocated CIDR into the lease, since subnet leases are keyed by node, not
+// by CIDR.
+func (a *EtcdIPAM) newSubnetLease(subnetCIDR string) *Lease {
+	lease := a.newLease()
+	lease.SubnetCIDR = subnetCIDR
+	return lease
+}
+
+func (a *EtcdIPAM) AllocateSubnet(nodeName string) (*api.Subnet, error) {
+	leases, err := a.store.ListSubnetLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := make([]string, 0, len(leases))
+	now := time.Now()
+	for _, lease := range leases {
+		if !lease.expired(now) {
+			inUse = append(inUse, lease.SubnetCIDR)
+		}
+	}
+
+	members := make([]string, len(a.clusterNetworkCIDRs))
+	for i, familyCIDR := range a.clusterNetworkCIDRs {
+		familyInUse, err := subnetsInUseForFamily(familyCIDR, inUse)
+		if err != nil {
+			return nil, err
+		}
+		subnetCIDR, err := nextFreeSubnet(familyCIDR, a.hostSubnetBits, familyInUse)
+		if err != nil {
+			return nil, err
+		}
+		members[i] = subnetCIDR
+	}
+	subnetCIDR := joinCIDRList(members)
+
+	if err := a.store.CreateSubnetLease(nodeName, subnetCIDR, a.newSubnetLease(subnetCIDR)); err != nil {
+		return nil, fmt.Errorf("error leasing subnet %s to node %s (another master may have won the race): %v", subnetCIDR, nodeName, err)
+	}
+
+	return &api.Subnet{NodeName: nodeName, SubnetCIDR: subnetCIDR}, nil
+}
+
+func (a *EtcdIPAM) ReleaseSubnet(nodeName string) error {
+	if _, err := a.store.GetSubnetLease(nodeName); err != nil {
+		return fmt.Errorf("no subnet leased to node %s: %v", nodeName, err)
+	}
+	return a.store.DeleteSubnetLease(nodeName)
+}
+
+func (a *EtcdIPAM) SubnetsInUse() ([]string, error) {
+	leases, err := a.store.ListSubnetLeases()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	subnets := make([]string, 0, len(leases))
+	for _, lease := range leases {
+		if !lease.expired(now) {
+			subnets = append(subnets, lease.SubnetCIDR)
+		}
+	}
+	return subnets, nil
+}
+
+func (a *EtcdIPAM) AllocateVNID(namespace string) (uint, error) {
+	leases, err := a.store.ListVNIDLeases()
+	if err != nil {
+		return 0, err
+	}
+
+	taken := make(map[uint]bool, len(leases))
+	now := time.Now()
+	for netID, lease := range leases {
+		if !lease.expired(now) {
+			taken[netID] = true
+		}
+	}
+
+	for netID := a.minVNID; netID <= a.maxVNID; netID++ {
+		if taken[netID] {
+			continue
+		}
+		if err := a.store.CreateVNIDLease(namespace, netID, a.newLease()); err != nil {
+			// Another master won the race for this VNID; try the next one.
+			continue
+		}
+		return netID, nil
+	}
+
+	return 0, fmt.Errorf("no VNIDs available in range [%d, %d]", a.minVNID, a.maxVNID)
+}
+
+func (a *EtcdIPAM) ReleaseVNID(namespace string) error {
+	if _, err := a.store.GetVNIDLease(namespace); err != nil {
+		return fmt.Errorf("no VNID leased to namespace %s: %v", namespace, err)
+	}
+	return a.store.DeleteVNIDLease(namespace)
+}
+
+// reservedSubnetHolder synthesizes a holder key for an administrator
+// reservation of subnet, which isn't actually leased to any node. Using the
+// subnet itself (guaranteed unique) avoids colliding with another static
+// subnet reservation the way a shared sentinel holder would.
+func reservedSubnetHolder(subnet string) string {
+	return "static-subnet:" + subnet
+}
+
+// reservedVNIDNamespace is reservedSubnetHolder's counterpart for a static
+// VNID reservation: it keys the lease off the VNID itself (also guaranteed
+// unique) instead of a shared sentinel namespace, which would otherwise
+// collide across more than one static VNID reservation. ":" can't appear in
+// a namespace name, so this can never collide with a real namespace either.
+func reservedVNIDNamespace(vnid uint) string {
+	return fmt.Sprintf("static-vnid:%d", vnid)
+}
+
+// RenewLeases refreshes the RenewTime of every non-expired subnet and VNID
+// lease on record, so they don't cross ttl and start looking abandoned to
+// AllocateSubnet/AllocateVNID's freshness check while the cluster (and
+// whichever node/namespace each lease belongs to) is actually still alive.
+// It's meant to be called periodically - e.g. every ttl/2 - by whichever
+// process is the active master; see ipam.Renewable.
+func (a *EtcdIPAM) RenewLeases() error {
+	var errs []string
+
+	subnetLeases, err := a.store.ListSubnetLeases()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for nodeName, lease := range subnetLeases {
+		if lease.expired(now) {
+			continue
+		}
+		if err := a.store.CompareAndSwapSubnetLease(nodeName, lease.RenewTime, a.newSubnetLease(lease.SubnetCIDR)); err != nil {
+			errs = append(errs, fmt.Sprintf("subnet lease for node %s: %v", nodeName, err))
+		}
+	}
+
+	vnidLeases, err := a.store.ListVNIDLeases()
+	if err != nil {
+		return err
+	}
+	for netID, lease := range vnidLeases {
+		if lease.expired(now) {
+			continue
+		}
+		if err := a.store.CompareAndSwapVNIDLease(lease.HolderIdentity, netID, lease.RenewTime, a.newLease()); err != nil {
+			errs = append(errs, fmt.Sprintf("VNID lease %d: %v", netID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to renew %d lease(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (a *EtcdIPAM) Reserve(subnet string, vnid uint) error {
+	if subnet != "" {
+		if err := a.store.CreateSubnetLease(reservedSubnetHolder(subnet), subnet, a.newSubnetLease(subnet)); err != nil {
+			return fmt.Errorf("error reserving subnet %s: %v", subnet, err)
+		}
+	}
+	if vnid != 0 {
+		if err := a.store.CreateVNIDLease(reservedVNIDNamespace(vnid), vnid, a.newLease()); err != nil {
+			return fmt.Errorf("error reserving VNID %d: %v", vnid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package ipam
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	subnetsByNode map[string]*Lease
+	vnids         map[uint]*Lease
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{subnetsByNode: make(map[string]*Lease), vnids: make(map[uint]*Lease)}
+}
+
+func (s *fakeStore) GetSubnetLease(nodeName string) (*Lease, error) {
+	if l, ok := s.subnetsByNode[nodeName]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("no lease for node %s", nodeName)
+}
+
+func (s *fakeStore) CreateSubnetLease(nodeName, subnetCIDR string, lease *Lease) error {
+	if existing, ok := s.subnetsByNode[nodeName]; ok && !existing.expired(time.Now()) {
+		return fmt.Errorf("node %s already has a subnet leased", nodeName)
+	}
+	for other, l := range s.subnetsByNode {
+		if other != nodeName && l.SubnetCIDR == subnetCIDR && !l.expired(time.Now()) {
+			return fmt.Errorf("subnet %s already leased", subnetCIDR)
+		}
+	}
+	lease.SubnetCIDR = subnetCIDR
+	s.subnetsByNode[nodeName] = lease
+	return nil
+}
+
+func (s *fakeStore) CompareAndSwapSubnetLease(nodeName string, expectedRenewTime time.Time, lease *Lease) error {
+	existing, ok := s.subnetsByNode[nodeName]
+	if !ok || !existing.RenewTime.Equal(expectedRenewTime) {
+		return fmt.Errorf("conflict renewing lease for node %s", nodeName)
+	}
+	lease.SubnetCIDR = existing.SubnetCIDR
+	s.subnetsByNode[nodeName] = lease
+	return nil
+}
+
+func (s *fakeStore) DeleteSubnetLease(nodeName string) error {
+	delete(s.subnetsByNode, nodeName)
+	return nil
+}
+
+func (s *fakeStore) ListSubnetLeases() (map[string]*Lease, error) {
+	return s.subnetsByNode, nil
+}
+
+func (s *fakeStore) GetVNIDLease(namespace string) (*Lease, error) {
+	for _, l := range s.vnids {
+		if l.HolderIdentity == namespace {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("no VNID leased to %s", namespace)
+}
+
+func (s *fakeStore) CreateVNIDLease(namespace string, netID uint, lease *Lease) error {
+	if existing, ok := s.vnids[netID]; ok && !existing.expired(time.Now()) {
+		return fmt.Errorf("VNID %d already leased", netID)
+	}
+	lease.HolderIdentity = namespace
+	s.vnids[netID] = lease
+	return nil
+}
+
+func (s *fakeStore) CompareAndSwapVNIDLease(namespace string, netID uint, expectedRenewTime time.Time, lease *Lease) error {
+	existing, ok := s.vnids[netID]
+	if !ok || existing.HolderIdentity != namespace || !existing.RenewTime.Equal(expectedRenewTime) {
+		return fmt.Errorf("conflict renewing VNID %d lease for namespace %s", netID, namespace)
+	}
+	lease.HolderIdentity = namespace
+	s.vnids[netID] = lease
+	return nil
+}
+
+func (s *fakeStore) DeleteVNIDLease(namespace string) error {
+	for netID, l := range s.vnids {
+		if l.HolderIdentity == namespace {
+			delete(s.vnids, netID)
+			return nil
+		}
+	}
+	return fmt.Errorf("no VNID leased to %s", namespace)
+}
+
+func (s *fakeStore) ListVNIDLeases() (map[uint]*Lease, error) {
+	return s.vnids, nil
+}
+
+func TestEtcdIPAMAllocateVNIDDoesNotReuseLiveLease(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 3)
+
+	first, err := a.AllocateVNID("ns-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := a.AllocateVNID("ns-b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Fatalf("expected distinct VNIDs, got %d twice", first)
+	}
+}
+
+func TestEtcdIPAMAllocateVNIDReusesExpiredLease(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 1)
+
+	netID, err := a.AllocateVNID("ns-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Simulate the master that held this lease having crashed: age it past
+	// its TTL so a different master can reclaim it.
+	store.vnids[netID].RenewTime = time.Now().Add(-2 * DefaultLeaseTTL)
+
+	reused, err := a.AllocateVNID("ns-b")
+	if err != nil {
+		t.Fatalf("expected the expired lease to be reclaimed, got error: %v", err)
+	}
+	if reused != netID {
+		t.Fatalf("expected VNID %d to be reused, got %d", netID, reused)
+	}
+}
+
+func TestEtcdIPAMReleaseVNIDUnknownNamespace(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 3)
+
+	if err := a.ReleaseVNID("never-allocated"); err == nil {
+		t.Fatalf("expected an error releasing a VNID that was never allocated")
+	}
+}
+
+func TestEtcdIPAMReserveDistinctStaticVNIDsDoNotCollide(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 100)
+
+	if err := a.Reserve("", 5); err != nil {
+		t.Fatalf("unexpected error reserving VNID 5: %v", err)
+	}
+	if err := a.Reserve("", 6); err != nil {
+		t.Fatalf("unexpected error reserving VNID 6: %v", err)
+	}
+
+	if _, ok := store.vnids[5]; !ok {
+		t.Errorf("expected VNID 5 to still be reserved")
+	}
+	if _, ok := store.vnids[6]; !ok {
+		t.Errorf("expected VNID 6 to still be reserved")
+	}
+}
+
+func TestEtcdIPAMAllocateSubnetThenReleaseByNode(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 3)
+
+	subnet, err := a.AllocateSubnet("node-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := a.ReleaseSubnet("node-a"); err != nil {
+		t.Fatalf("expected ReleaseSubnet to find node-a's lease, got error: %v", err)
+	}
+
+	inUse, err := a.SubnetsInUse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, cidr := range inUse {
+		if cidr == subnet.SubnetCIDR {
+			t.Fatalf("expected %s to be released, but it is still in use", subnet.SubnetCIDR)
+		}
+	}
+
+	if err := a.ReleaseSubnet("node-a"); err == nil {
+		t.Fatalf("expected releasing an already-released node's subnet to fail")
+	}
+}
+
+func TestEtcdIPAMRenewLeasesKeepsLiveLeasesFromExpiring(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 3)
+
+	subnet, err := a.AllocateSubnet("node-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	netID, err := a.AllocateVNID("ns-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Age both leases to just short of their TTL, as if renewal had not run
+	// for almost a whole TTL; without renewal they'd expire within seconds.
+	store.subnetsByNode["node-a"].RenewTime = time.Now().Add(-(DefaultLeaseTTL - 10*time.Second))
+	store.vnids[netID].RenewTime = time.Now().Add(-(DefaultLeaseTTL - 10*time.Second))
+
+	if err := a.RenewLeases(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	later := time.Now().Add(DefaultLeaseTTL / 2)
+	if store.subnetsByNode["node-a"].expired(later) {
+		t.Errorf("expected the subnet lease to be refreshed by RenewLeases")
+	}
+	if store.vnids[netID].expired(later) {
+		t.Errorf("expected the VNID lease to be refreshed by RenewLeases")
+	}
+
+	// A second master must still be unable to steal node-a's subnet.
+	second, err := a.AllocateSubnet("node-b")
+	if err != nil {
+		t.Fatalf("unexpected error allocating node-b's subnet: %v", err)
+	}
+	if second.SubnetCIDR == subnet.SubnetCIDR {
+		t.Fatalf("node-a's renewed (still-live) subnet was reallocated to node-b")
+	}
+}
+
+func TestEtcdIPAMRenewLeasesSkipsAlreadyExpiredLeases(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14", 9, 1, 3)
+
+	if _, err := a.AllocateSubnet("node-a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expiredRenewTime := time.Now().Add(-2 * DefaultLeaseTTL)
+	store.subnetsByNode["node-a"].RenewTime = expiredRenewTime
+
+	if err := a.RenewLeases(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !store.subnetsByNode["node-a"].RenewTime.Equal(expiredRenewTime) {
+		t.Errorf("expected an already-expired lease to be left alone, not renewed")
+	}
+}
+
+func TestEtcdIPAMAllocateSubnetDualStack(t *testing.T) {
+	store := newFakeStore()
+	a := NewEtcdIPAM(store, "master-1", DefaultLeaseTTL, "10.128.0.0/14,fd02::/48", 9, 1, 3)
+
+	subnet, err := a.AllocateSubnet("node-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	members := splitCIDRList(subnet.SubnetCIDR)
+	if len(members) != 2 {
+		t.Fatalf("expected a dual-stack SubnetCIDR with 2 members, got %q", subnet.SubnetCIDR)
+	}
+
+	if err := a.ReleaseSubnet("node-a"); err != nil {
+		t.Fatalf("unexpected error releasing dual-stack subnet: %v", err)
+	}
+}
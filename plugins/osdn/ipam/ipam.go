@@ -0,0 +1,103 @@
+// Package ipam provides the pluggable backends OvsController uses to
+// allocate per-node subnets and per-namespace VNIDs.
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/openshift/openshift-sdn/plugins/osdn/api"
+)
+
+// IPAM allocates and releases the two kinds of leases StartMaster hands
+// out: a pod subnet per node, and a VNID per namespace. Implementations
+// range from a simple in-process allocator (fine for a single master) to
+// one backed by etcd/a CRD, which can run safely behind multiple masters
+// racing each other and survive a master crashing mid-allocation.
+//
+// clusterNetworkCIDR, and the SubnetCIDR an implementation hands back, may
+// each be either a single IPv4/IPv6 CIDR or a comma-separated dual-stack
+// "v4,v6" pair; implementations allocate (and release) one subnet per
+// family present and rejoin them with a comma in the same order.
+type IPAM interface {
+	// AllocateSubnet picks an unused subnet of the configured size and
+	// leases it to nodeName.
+	AllocateSubnet(nodeName string) (*api.Subnet, error)
+	// ReleaseSubnet gives back the subnet leased to nodeName.
+	ReleaseSubnet(nodeName string) error
+	// SubnetsInUse lists the CIDRs currently leased out, for validating a
+	// new/changed cluster network against existing node subnets.
+	SubnetsInUse() ([]string, error)
+
+	// AllocateVNID picks an unused VNID and leases it to namespace.
+	AllocateVNID(namespace string) (uint, error)
+	// ReleaseVNID gives back the VNID leased to namespace.
+	ReleaseVNID(namespace string) error
+
+	// Reserve marks subnet and/or vnid as already in use without handing
+	// out a lease for them, e.g. for an administrator-requested static
+	// subnet assignment.
+	Reserve(subnet string, vnid uint) error
+}
+
+// Renewable is implemented by IPAM backends whose leases expire unless
+// periodically renewed (EtcdIPAM, via its TTL). StartMaster type-asserts
+// its ipam backend against this and, if it matches, runs RenewLeases on a
+// ticker for as long as the process is up, so a healthy master's leases
+// never look abandoned to its own (or another master's) freshness check.
+type Renewable interface {
+	RenewLeases() error
+}
+
+// splitCIDRList splits a comma-separated CIDR string (the dual-stack
+// "v4,v6" form clusterNetworkCIDR/api.Subnet.SubnetCIDR use) into its
+// members, preserving order.
+func splitCIDRList(cidrs string) []string {
+	var result []string
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr != "" {
+			result = append(result, cidr)
+		}
+	}
+	return result
+}
+
+// joinCIDRList is the inverse of splitCIDRList.
+func joinCIDRList(cidrs []string) string {
+	return strings.Join(cidrs, ",")
+}
+
+// isIPv4CIDR reports whether cidr's network address is IPv4.
+func isIPv4CIDR(cidr string) (bool, error) {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, fmt.Errorf("Failed to parse network address: %s", cidr)
+	}
+	return ip.To4() != nil, nil
+}
+
+// subnetsInUseForFamily picks out the member of each (possibly
+// comma-joined, dual-stack) entry of subnetsInUse that belongs to the same
+// address family as familyCIDR.
+func subnetsInUseForFamily(familyCIDR string, subnetsInUse []string) ([]string, error) {
+	familyIsV4, err := isIPv4CIDR(familyCIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, subnet := range subnetsInUse {
+		for _, member := range splitCIDRList(subnet) {
+			isV4, err := isIPv4CIDR(member)
+			if err != nil {
+				return nil, err
+			}
+			if isV4 == familyIsV4 {
+				result = append(result, member)
+			}
+		}
+	}
+	return result, nil
+}
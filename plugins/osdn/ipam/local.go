@@ -0,0 +1,165 @@
+package ipam
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/plugins/osdn/api"
+)
+
+// LocalIPAM is the original in-process allocation strategy: subnets and
+// VNIDs are handed out from in-memory free lists seeded once at startup
+// from the registry. It has no notion of a lease holder or TTL, so it only
+// gives correct results with a single, non-racing master.
+type LocalIPAM struct {
+	lock sync.Mutex
+	// subnetAllocators holds one allocator per member of the (possibly
+	// dual-stack) cluster network, in the same order as the CIDR string
+	// NewLocalIPAM's caller passed; AllocateSubnet draws one subnet from
+	// each and rejoins them into a single comma-separated SubnetCIDR.
+	subnetAllocators []*netutils.SubnetAllocator
+	netIDAllocator   *netutils.NetIDAllocator
+
+	nodeSubnets    map[string]string
+	namespaceVNIDs map[string]uint
+}
+
+// NewLocalIPAM seeds a LocalIPAM from the subnets/VNIDs already recorded in
+// the registry, so restarting the master doesn't re-allocate addresses
+// already leased to running nodes/namespaces. clusterNetworkCIDR may be a
+// single IPv4/IPv6 CIDR or a comma-separated dual-stack "v4,v6" pair; one
+// allocator is built per family present.
+func NewLocalIPAM(clusterNetworkCIDR string, hostSubnetBits uint, minVNID, maxVNID uint, subnetsInUse []string, vnidsInUse []uint) (*LocalIPAM, error) {
+	families := splitCIDRList(clusterNetworkCIDR)
+	if len(families) == 0 {
+		return nil, fmt.Errorf("No valid network CIDR found in %q", clusterNetworkCIDR)
+	}
+
+	subnetAllocators := make([]*netutils.SubnetAllocator, len(families))
+	for i, familyCIDR := range families {
+		familyInUse, err := subnetsInUseForFamily(familyCIDR, subnetsInUse)
+		if err != nil {
+			return nil, err
+		}
+		allocator, err := netutils.NewSubnetAllocator(familyCIDR, hostSubnetBits, familyInUse)
+		if err != nil {
+			return nil, err
+		}
+		subnetAllocators[i] = allocator
+	}
+
+	netIDAllocator, err := netutils.NewNetIDAllocator(minVNID, maxVNID, vnidsInUse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalIPAM{
+		subnetAllocators: subnetAllocators,
+		netIDAllocator:   netIDAllocator,
+		nodeSubnets:      make(map[string]string),
+		namespaceVNIDs:   make(map[string]uint),
+	}, nil
+}
+
+func (a *LocalIPAM) AllocateSubnet(nodeName string) (*api.Subnet, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	members := make([]string, len(a.subnetAllocators))
+	for i, allocator := range a.subnetAllocators {
+		subnetCIDR, err := allocator.GetNetwork()
+		if err != nil {
+			// Give back whatever families already succeeded in this call
+			// so a partial failure doesn't leak a subnet.
+			for j := 0; j < i; j++ {
+				a.subnetAllocators[j].ReleaseNetwork(members[j])
+			}
+			return nil, fmt.Errorf("error allocating subnet for node %s: %v", nodeName, err)
+		}
+		members[i] = subnetCIDR
+	}
+
+	subnetCIDR := joinCIDRList(members)
+	a.nodeSubnets[nodeName] = subnetCIDR
+	return &api.Subnet{NodeName: nodeName, SubnetCIDR: subnetCIDR}, nil
+}
+
+func (a *LocalIPAM) ReleaseSubnet(nodeName string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	subnetCIDR, ok := a.nodeSubnets[nodeName]
+	if !ok {
+		return fmt.Errorf("no subnet leased to node %s", nodeName)
+	}
+	delete(a.nodeSubnets, nodeName)
+
+	for i, member := range splitCIDRList(subnetCIDR) {
+		if i >= len(a.subnetAllocators) {
+			break
+		}
+		if err := a.subnetAllocators[i].ReleaseNetwork(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *LocalIPAM) SubnetsInUse() ([]string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	subnets := make([]string, 0, len(a.nodeSubnets))
+	for _, subnetCIDR := range a.nodeSubnets {
+		subnets = append(subnets, subnetCIDR)
+	}
+	return subnets, nil
+}
+
+func (a *LocalIPAM) AllocateVNID(namespace string) (uint, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	netID, err := a.netIDAllocator.GetNetID()
+	if err != nil {
+		return 0, fmt.Errorf("error allocating VNID for namespace %s: %v", namespace, err)
+	}
+	a.namespaceVNIDs[namespace] = netID
+	return netID, nil
+}
+
+func (a *LocalIPAM) ReleaseVNID(namespace string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	netID, ok := a.namespaceVNIDs[namespace]
+	if !ok {
+		return fmt.Errorf("no VNID leased to namespace %s", namespace)
+	}
+	delete(a.namespaceVNIDs, namespace)
+	return a.netIDAllocator.ReleaseNetID(netID)
+}
+
+func (a *LocalIPAM) Reserve(subnet string, vnid uint) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if subnet != "" {
+		members := splitCIDRList(subnet)
+		if len(members) != len(a.subnetAllocators) {
+			return fmt.Errorf("reserved subnet %q does not have one member per cluster network family", subnet)
+		}
+		for i, member := range members {
+			if err := a.subnetAllocators[i].MarkAllocatedNetwork(member); err != nil {
+				return err
+			}
+		}
+	}
+	if vnid != 0 {
+		if err := a.netIDAllocator.MarkAllocatedNetID(vnid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
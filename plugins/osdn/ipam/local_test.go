@@ -0,0 +1,49 @@
+package ipam
+
+import "testing"
+
+func TestLocalIPAMAllocateSubnetDualStack(t *testing.T) {
+	a, err := NewLocalIPAM("10.128.0.0/14,fd02::/48", 9, 1, 3, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subnet, err := a.AllocateSubnet("node-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	members := splitCIDRList(subnet.SubnetCIDR)
+	if len(members) != 2 {
+		t.Fatalf("expected a dual-stack SubnetCIDR with 2 members, got %q", subnet.SubnetCIDR)
+	}
+
+	if err := a.ReleaseSubnet("node-a"); err != nil {
+		t.Fatalf("unexpected error releasing dual-stack subnet: %v", err)
+	}
+
+	// The released subnet should be allocatable again.
+	second, err := a.AllocateSubnet("node-b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.SubnetCIDR != subnet.SubnetCIDR {
+		t.Errorf("expected released subnet %q to be reused, got %q", subnet.SubnetCIDR, second.SubnetCIDR)
+	}
+}
+
+func TestLocalIPAMNewLocalIPAMSeedsSubnetsInUsePerFamily(t *testing.T) {
+	inUse := []string{"10.128.0.0/23,fd02::/64"}
+	a, err := NewLocalIPAM("10.128.0.0/14,fd02::/48", 9, 1, 3, inUse, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subnet, err := a.AllocateSubnet("node-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if subnet.SubnetCIDR == inUse[0] {
+		t.Fatalf("expected already-in-use subnet %q not to be re-allocated", inUse[0])
+	}
+}
@@ -0,0 +1,141 @@
+package osdn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+
+	log "github.com/golang/glog"
+
+	"k8s.io/kubernetes/pkg/util/sysctl"
+)
+
+// sysctlSetting is one kernel knob StartNode verifies (and, with
+// --tune-sysctls, writes) before standing up the node's networking.
+type sysctlSetting struct {
+	// name is the sysctl key, e.g. "net/ipv4/ip_forward", or a
+	// device-relative one such as "net/ipv4/conf/%s/rp_filter" that gets
+	// expanded once per device in devices.
+	name     string
+	devices  []string
+	value    int
+	required bool
+}
+
+func ifaceSysctls(devices []string) []sysctlSetting {
+	return []sysctlSetting{
+		{name: "net/ipv4/ip_forward", value: 1, required: true},
+		{name: "net/ipv4/conf/%s/rp_filter", devices: devices, value: 0, required: true},
+		{name: "net/bridge/bridge-nf-call-iptables", value: 1, required: false},
+		{name: "net/ipv4/conf/all/route_localnet", value: 1, required: false},
+		{name: "net/netfilter/nf_conntrack_max", value: conntrackMaxForNodeSize(), required: false},
+	}
+}
+
+// conntrackMaxForNodeSize picks a nf_conntrack_max appropriate to the node's
+// size. There's no portable, dependency-free way to read total memory here,
+// so this scales with CPU count instead, following the same rule of thumb
+// kube-proxy's conntracker uses for its minimum.
+func conntrackMaxForNodeSize() int {
+	max := runtime.NumCPU() * 32768
+	if max < 131072 {
+		max = 131072
+	}
+	if max > 1000000 {
+		max = 1000000
+	}
+	return max
+}
+
+// SysctlResult is the outcome of checking (and possibly tuning) a single
+// kernel knob.
+type SysctlResult struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SysctlStatus is the full preflight outcome, retained on the
+// OvsController so it can be surfaced through a readiness probe.
+type SysctlStatus struct {
+	OK      bool           `json:"ok"`
+	Results []SysctlResult `json:"results"`
+}
+
+var sysctlStatusLock sync.Mutex
+
+// sysctlPreflight checks (and, if tune is true, writes) the kernel knobs
+// SDN needs, via iface (sysctl.New() in production; a fake in tests, the
+// same way SetupIptables takes an iptables.Interface). A failure on a
+// required knob is returned as an error, aborting StartNode; a failure on
+// an optional knob is only logged.
+func sysctlPreflight(iface sysctl.Interface, devices []string, tune bool) (*SysctlStatus, error) {
+	status := &SysctlStatus{OK: true}
+
+	for _, setting := range ifaceSysctls(devices) {
+		names := []string{setting.name}
+		if len(setting.devices) > 0 {
+			names = make([]string, len(setting.devices))
+			for i, dev := range setting.devices {
+				names[i] = fmt.Sprintf(setting.name, dev)
+			}
+		}
+
+		for _, name := range names {
+			result := SysctlResult{Name: name, Required: setting.required}
+
+			current, err := iface.GetSysctl(name)
+			if err == nil && current == setting.value {
+				result.OK = true
+			} else if tune {
+				if err := iface.SetSysctl(name, setting.value); err != nil {
+					result.Error = fmt.Sprintf("failed to set %s=%d: %v", name, setting.value, err)
+				} else {
+					result.OK = true
+				}
+			} else if err != nil {
+				result.Error = fmt.Sprintf("failed to read %s: %v", name, err)
+			} else {
+				result.Error = fmt.Sprintf("%s is %d, want %d (pass --tune-sysctls to set it automatically)", name, current, setting.value)
+			}
+
+			if !result.OK {
+				status.OK = false
+				if setting.required {
+					status.Results = append(status.Results, result)
+					return status, fmt.Errorf(result.Error)
+				}
+				log.Warningf("Optional sysctl preflight check failed: %s", result.Error)
+			}
+			status.Results = append(status.Results, result)
+		}
+	}
+
+	return status, nil
+}
+
+// ServeSysctlReadiness is an HTTP handler operators can wire into a
+// readiness probe to detect a misconfigured node before pods land on it. It
+// returns 200 with the JSON SysctlStatus if every knob passed, or 503 (with
+// the same JSON) if any knob failed, optional ones included.
+func (oc *OvsController) ServeSysctlReadiness(w http.ResponseWriter, r *http.Request) {
+	sysctlStatusLock.Lock()
+	status := oc.sysctlStatus
+	sysctlStatusLock.Unlock()
+
+	if status == nil {
+		http.Error(w, "sysctl preflight has not run yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Errorf("Error encoding sysctl readiness response: %v", err)
+	}
+}
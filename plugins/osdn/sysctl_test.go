@@ -0,0 +1,171 @@
+package osdn
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeSysctl is an in-memory sysctl.Interface so sysctlPreflight's
+// required-vs-optional handling, tune vs. read-only behavior, and error
+// paths can be exercised without real /proc access.
+type fakeSysctl struct {
+	values map[string]int
+	setErr error
+}
+
+func newFakeSysctl(values map[string]int) *fakeSysctl {
+	return &fakeSysctl{values: values}
+}
+
+func (f *fakeSysctl) GetSysctl(name string) (int, error) {
+	v, ok := f.values[name]
+	if !ok {
+		return 0, errors.New("no such sysctl: " + name)
+	}
+	return v, nil
+}
+
+func (f *fakeSysctl) SetSysctl(name string, newVal int) error {
+	if f.setErr != nil {
+		return f.setErr
+	}
+	if f.values == nil {
+		f.values = make(map[string]int)
+	}
+	f.values[name] = newVal
+	return nil
+}
+
+// allCorrectValues builds the set of sysctl values ifaceSysctls(devices)
+// expects, so a single test can start from an all-passing baseline and then
+// perturb just the one knob it's testing.
+func allCorrectValues(devices []string) map[string]int {
+	values := make(map[string]int)
+	for _, setting := range ifaceSysctls(devices) {
+		if len(setting.devices) > 0 {
+			for _, dev := range setting.devices {
+				values[fmt.Sprintf(setting.name, dev)] = setting.value
+			}
+			continue
+		}
+		values[setting.name] = setting.value
+	}
+	return values
+}
+
+func TestConntrackMaxForNodeSizeIsBounded(t *testing.T) {
+	max := conntrackMaxForNodeSize()
+	if max < 131072 {
+		t.Errorf("expected conntrackMaxForNodeSize to be at least 131072, got %d", max)
+	}
+	if max > 1000000 {
+		t.Errorf("expected conntrackMaxForNodeSize to be at most 1000000, got %d", max)
+	}
+}
+
+func TestIfaceSysctlsExpandsPerDevice(t *testing.T) {
+	settings := ifaceSysctls([]string{"tun0", "lbr0"})
+
+	var found int
+	for _, s := range settings {
+		if s.name == "net/ipv4/conf/%s/rp_filter" {
+			found++
+			if len(s.devices) != 2 {
+				t.Errorf("expected rp_filter setting to carry 2 devices, got %d", len(s.devices))
+			}
+			if !s.required {
+				t.Errorf("expected rp_filter to be a required knob")
+			}
+		}
+	}
+	if found != 1 {
+		t.Fatalf("expected exactly one rp_filter setting, found %d", found)
+	}
+}
+
+func TestSysctlPreflightPassesWhenAlreadyCorrect(t *testing.T) {
+	devices := []string{"tun0"}
+	iface := newFakeSysctl(allCorrectValues(devices))
+
+	status, err := sysctlPreflight(iface, devices, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.OK {
+		t.Errorf("expected status.OK, got %+v", status)
+	}
+}
+
+func TestSysctlPreflightRequiredKnobFailsWithoutTune(t *testing.T) {
+	devices := []string{"tun0"}
+	values := allCorrectValues(devices)
+	values["net/ipv4/ip_forward"] = 0
+	iface := newFakeSysctl(values)
+
+	status, err := sysctlPreflight(iface, devices, false)
+	if err == nil {
+		t.Fatalf("expected an error when a required knob is wrong and tune is false")
+	}
+	if status.OK {
+		t.Errorf("expected status.OK to be false")
+	}
+}
+
+func TestSysctlPreflightTunesRequiredKnob(t *testing.T) {
+	devices := []string{"tun0"}
+	values := allCorrectValues(devices)
+	values["net/ipv4/ip_forward"] = 0
+	iface := newFakeSysctl(values)
+
+	status, err := sysctlPreflight(iface, devices, true)
+	if err != nil {
+		t.Fatalf("expected tuning the required knob to succeed, got error: %v", err)
+	}
+	if !status.OK {
+		t.Errorf("expected status.OK after tuning, got %+v", status)
+	}
+	if got, _ := iface.GetSysctl("net/ipv4/ip_forward"); got != 1 {
+		t.Errorf("expected ip_forward to be tuned to 1, got %d", got)
+	}
+}
+
+func TestSysctlPreflightOptionalKnobFailureDoesNotAbort(t *testing.T) {
+	devices := []string{"tun0"}
+	values := allCorrectValues(devices)
+	values["net/bridge/bridge-nf-call-iptables"] = 0
+	iface := newFakeSysctl(values)
+
+	status, err := sysctlPreflight(iface, devices, false)
+	if err != nil {
+		t.Fatalf("expected an optional knob failure not to abort preflight, got error: %v", err)
+	}
+	if status.OK {
+		t.Errorf("expected status.OK to be false when an optional knob fails")
+	}
+
+	var found bool
+	for _, r := range status.Results {
+		if r.Name == "net/bridge/bridge-nf-call-iptables" {
+			found = true
+			if r.OK {
+				t.Errorf("expected the optional knob's result to be recorded as failing")
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected a result for the optional knob")
+	}
+}
+
+func TestSysctlPreflightSetSysctlErrorPropagatesForRequired(t *testing.T) {
+	devices := []string{"tun0"}
+	values := allCorrectValues(devices)
+	values["net/ipv4/ip_forward"] = 0
+	iface := newFakeSysctl(values)
+	iface.setErr = errors.New("permission denied")
+
+	if _, err := sysctlPreflight(iface, devices, true); err == nil {
+		t.Fatalf("expected SetSysctl's error to propagate for a required knob")
+	}
+}